@@ -0,0 +1,191 @@
+// mini-tco は末尾再帰関数をループ/trampoline に書き換える gofmt 風の CLI。
+// 実際の書き換えロジックは github.com/silasolla/golang-mini-tco/tco が
+// 持っており，このファイルはフラグ解析と入出力だけを担う薄いラッパー。
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "go/parser"
+    "go/token"
+    "io"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+
+    "golang.org/x/tools/go/packages"
+
+    "github.com/silasolla/golang-mini-tco/tco"
+)
+
+func main() {
+    write := flag.Bool("w", false, "write result to (source) file instead of stdout")
+    diffFlag := flag.Bool("d", false, "display diffs instead of rewriting files")
+    list := flag.Bool("l", false, "list files that the tail-call rewrite would change")
+    trampoline := flag.Bool("trampoline", false, "互いに末尾呼び出ししあう関数群を trampoline 形式に書き換える")
+    tags := flag.String("tags", "", "comma-separated list of build tags to consider satisfied")
+    flag.Parse()
+
+    args := flag.Args()
+    if len(args) == 0 {
+        args = []string{"."}
+    }
+
+    files, err := resolveFiles(args, *tags)
+    if err != nil {
+        fmt.Fprintln(os.Stderr, "mini-tco:", err)
+        os.Exit(1)
+    }
+
+    exitCode := 0
+    for _, path := range files {
+        if err := processFile(path, *write, *diffFlag, *list, *trampoline); err != nil {
+            fmt.Fprintln(os.Stderr, "mini-tco:", err)
+            exitCode = 1
+        }
+    }
+
+    os.Exit(exitCode)
+}
+
+// resolveFiles は gofmt/goimports と同じように，ファイルパス・ディレクトリ・
+// "./..." のようなパッケージパターンのいずれも受け付ける。ディレクトリは
+// ファイルシステムを辿って .go ファイルに展開し，それ以外 (パスとして
+// 存在しないもの) は go/packages 経由でパッケージパターンとして解決する。
+func resolveFiles(args []string, tags string) ([]string, error) {
+    var files []string
+    var patterns []string
+
+    for _, arg := range args {
+        info, err := os.Stat(arg)
+        switch {
+        case err == nil && info.IsDir():
+            walkErr := filepath.Walk(arg, func(path string, fi os.FileInfo, err error) error {
+                if err != nil {
+                    return err
+                }
+                if fi.IsDir() {
+                    return nil
+                }
+                if strings.HasSuffix(path, ".go") {
+                    files = append(files, path)
+                }
+                return nil
+            })
+            if walkErr != nil {
+                return nil, walkErr
+            }
+
+        case err == nil:
+            files = append(files, arg)
+
+        default:
+            patterns = append(patterns, arg)
+        }
+    }
+
+    if len(patterns) > 0 {
+        cfg := &packages.Config{Mode: packages.NeedName | packages.NeedFiles}
+        if tags != "" {
+            cfg.BuildFlags = []string{"-tags", tags}
+        }
+
+        pkgs, err := packages.Load(cfg, patterns...)
+        if err != nil {
+            return nil, err
+        }
+        for _, pkg := range pkgs {
+            files = append(files, pkg.GoFiles...)
+        }
+    }
+
+    return files, nil
+}
+
+// processFile は1つの .go ファイルを読み込んで Transform し，フラグに
+// 応じて標準出力・ファイルへの書き戻し・diff 表示・ファイル名一覧のいずれかで
+// 結果を報告する。
+func processFile(path string, write, diffMode, list, trampoline bool) error {
+    fset := token.NewFileSet()
+    src, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+
+    file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+    if err != nil {
+        return err
+    }
+
+    var changed bool
+    if trampoline {
+        changed, err = tco.TransformWithTrampoline(fset, file)
+    } else {
+        changed, err = tco.Transform(fset, file)
+    }
+    if err != nil {
+        return err
+    }
+    if !changed {
+        return nil
+    }
+
+    out, err := tco.Format(fset, file)
+    if err != nil {
+        return err
+    }
+
+    switch {
+    case list:
+        fmt.Println(path)
+        return nil
+    case diffMode:
+        return printDiff(path, src, out)
+    case write:
+        return os.WriteFile(path, out, 0o644)
+    default:
+        _, err := io.Copy(os.Stdout, bytes.NewReader(out))
+        return err
+    }
+}
+
+// printDiff は diff(1) を呼び出して unified diff を表示する (gofmt -d と
+// 同じ実装方針)。diff コマンドが無い環境ではそのエラーをそのまま返す。
+func printDiff(path string, before, after []byte) error {
+    beforeFile, err := os.CreateTemp("", "mini-tco-orig-*.go")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(beforeFile.Name())
+    defer beforeFile.Close()
+
+    afterFile, err := os.CreateTemp("", "mini-tco-new-*.go")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(afterFile.Name())
+    defer afterFile.Close()
+
+    if _, err := beforeFile.Write(before); err != nil {
+        return err
+    }
+    if _, err := afterFile.Write(after); err != nil {
+        return err
+    }
+
+    out, err := exec.Command("diff", "-u", beforeFile.Name(), afterFile.Name()).CombinedOutput()
+    if len(out) > 0 {
+        fmt.Printf("diff %s %s\n", path, path)
+        os.Stdout.Write(out)
+    }
+    if err != nil {
+        if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+            // diff は差分がある場合に終了コード1を返す。これは想定内なので無視する
+            return nil
+        }
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,1035 @@
+// Package tco は末尾再帰関数をループ (あるいは相互再帰の場合は trampoline) に
+// 書き換えるためのライブラリ。ファイルシステムや CLI フラグには関知せず，
+// 呼び出し側 (cmd/mini-tco など) がすでに読み込んだ *ast.File を渡して使う。
+package tco
+
+import (
+    "bytes"
+    "fmt"
+    "go/ast"
+    "go/format"
+    "go/printer"
+    "go/token"
+    "sort"
+    "strings"
+
+    "golang.org/x/tools/go/ast/astutil"
+)
+
+// Transform は file 中の末尾再帰関数を (自己再帰なら) 無限ループに，
+// (相互再帰なら) そのまま手を付けずに書き換える。書き換えが1箇所でも
+// 行われれば changed=true を返す。
+func Transform(fset *token.FileSet, file *ast.File) (bool, error) {
+    return transform(fset, file, false)
+}
+
+// TransformWithTrampoline は Transform と同様だが，互いに末尾呼び出し
+// しあう関数群 (相互再帰) も trampoline 形式に書き換える。
+func TransformWithTrampoline(fset *token.FileSet, file *ast.File) (bool, error) {
+    return transform(fset, file, true)
+}
+
+func transform(fset *token.FileSet, file *ast.File, trampoline bool) (bool, error) {
+    // 書き換えで消える/作り直されるノードのコメントを追跡できるように，
+    // 変更前の木から CommentMap を作っておく
+    cmap := ast.NewCommentMap(fset, file, file.Comments)
+    changed := false
+
+    // 末尾呼び出しグラフを作り，強連結成分 (相互再帰のまとまり) を求める
+    funcs := funcsByName(file)
+    edges := map[string]map[string]bool{}
+    for name, fn := range funcs {
+        edges[name] = tailCallees(fn, funcs)
+    }
+    sccs := stronglyConnectedComponents(funcs, edges)
+
+    mutual := map[string]bool{}
+    for _, scc := range sccs {
+        if len(scc) > 1 {
+            for _, name := range scc {
+                mutual[name] = true
+            }
+        }
+    }
+
+    if trampoline {
+        used := collectUsedNames(file)
+        for _, scc := range sccs {
+            if len(scc) > 1 {
+                if applyTrampoline(file, cmap, used, funcs, scc) {
+                    changed = true
+                }
+            }
+        }
+    }
+
+    // SCC のサイズが1 (自己再帰，もしくは末尾再帰なし) の場合は
+    // 従来通りループへの書き換えで済ませる (fast path)
+    for _, decl := range file.Decls {
+        fn, ok := decl.(*ast.FuncDecl)
+        if !ok || fn.Body == nil || mutual[fn.Name.Name] {
+            continue
+        }
+
+        if !hasTailRecursion(fn) {
+            continue
+        }
+
+        used := collectUsedNames(fn)
+        newBody, fnChanged := rewriteBlock(fn, cmap, used, fn.Body.List)
+
+        // hasTailRecursion は for/range/select の中まで踏み込んで再帰呼び出しを
+        // 見つけてしまうことがあるが，rewriteBlock はそこまでは書き換えない。
+        // 実際に continue へ変換できた場合だけ changed=true とし，無意味な
+        // for{} で包むことのないようにする。
+        if !fnChanged {
+            continue
+        }
+
+        // 本文全体を無限ループで包む。終了条件は特定の BinaryExpr の否定ではなく，
+        // 本文中に残る (末尾再帰ではない) return がそのままループを抜ける
+        fn.Body.List = []ast.Stmt{
+            &ast.ForStmt{
+                Body: &ast.BlockStmt{
+                    List: newBody,
+                },
+            },
+        }
+        changed = true
+    }
+
+    // 書き換え後の木に残っているノードのコメントだけを残す
+    file.Comments = cmap.Filter(file).Comments()
+
+    return changed, nil
+}
+
+// Format は file を gofmt と byte-identical なソースに整形する。
+func Format(fset *token.FileSet, file *ast.File) ([]byte, error) {
+    var buf bytes.Buffer
+    cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+    if err := cfg.Fprint(&buf, fset, file); err != nil {
+        return nil, err
+    }
+    return format.Source(buf.Bytes())
+}
+
+// oldNode に付いていたコメントを cmap から外し，newNode に付け替える。
+// 文を丸ごと別の文に置き換えるときに，コメントが消えてしまわないようにする。
+func moveComments(cmap ast.CommentMap, oldNode, newNode ast.Node) {
+    groups, ok := cmap[oldNode]
+    if !ok {
+        return
+    }
+    cmap[newNode] = append(cmap[newNode], groups...)
+    delete(cmap, oldNode)
+}
+
+// return 文の結果式の中から，isTarget を満たす関数への呼び出しを1つ探す。
+// 該当する呼び出しが2つ以上混ざっている場合は判定できないので見つからな
+// かったことにする。
+func tailCallIdent(ret *ast.ReturnStmt, isTarget func(name string) bool) (*ast.CallExpr, string, bool) {
+    var found *ast.CallExpr
+    var foundName string
+
+    for _, result := range ret.Results {
+        callExpr, ok := result.(*ast.CallExpr)
+        if !ok {
+            continue
+        }
+
+        funIdent, ok := callExpr.Fun.(*ast.Ident)
+        if !ok || !isTarget(funIdent.Name) {
+            continue
+        }
+
+        if found != nil {
+            return nil, "", false
+        }
+        found = callExpr
+        foundName = funIdent.Name
+    }
+
+    return found, foundName, found != nil
+}
+
+// return 文の中から fn 自身への末尾再帰呼び出しを探す。
+// 戻り値が1つだけでそれが呼び出しである場合 (f 自身が複数の値を返す関数で，
+// その呼び出し結果をそのまま forward する場合) と，(T, error) のように
+// 複数の戻り値の中に再帰呼び出しが1つだけ混ざっている場合
+// (e.g. return fact(n-1, n*acc), nil) の両方を受け付ける。
+func recursiveCall(fn *ast.FuncDecl, ret *ast.ReturnStmt) (*ast.CallExpr, bool) {
+    call, _, ok := tailCallIdent(ret, func(name string) bool { return name == fn.Name.Name })
+    return call, ok
+}
+
+// fn.Body の中に自己再帰の末尾呼び出しが1つでも存在するか ast.Inspect で確認する
+// (無名関数の中は別スコープなので踏み込まない)
+func hasTailRecursion(fn *ast.FuncDecl) bool {
+    found := false
+
+    ast.Inspect(fn.Body, func(n ast.Node) bool {
+        if found {
+            return false
+        }
+
+        if _, ok := n.(*ast.FuncLit); ok {
+            return false
+        }
+
+        if ret, ok := n.(*ast.ReturnStmt); ok {
+            if _, ok := recursiveCall(fn, ret); ok {
+                found = true
+                return false
+            }
+        }
+
+        return true
+    })
+
+    return found
+}
+
+// ファイル中のトップレベル関数 (メソッドは除く) を名前で引けるようにする
+func funcsByName(file *ast.File) map[string]*ast.FuncDecl {
+    funcs := map[string]*ast.FuncDecl{}
+
+    for _, decl := range file.Decls {
+        fn, ok := decl.(*ast.FuncDecl)
+        if !ok || fn.Body == nil || fn.Recv != nil {
+            continue
+        }
+        funcs[fn.Name.Name] = fn
+    }
+
+    return funcs
+}
+
+// fn.Body の中で末尾位置から呼ばれている funcs 内の関数名をすべて集める
+// (無名関数の中は別スコープなので踏み込まない)。相互再帰を見つけるための
+// 呼び出しグラフの辺として使う。
+func tailCallees(fn *ast.FuncDecl, funcs map[string]*ast.FuncDecl) map[string]bool {
+    callees := map[string]bool{}
+
+    isTarget := func(name string) bool {
+        _, ok := funcs[name]
+        return ok
+    }
+
+    ast.Inspect(fn.Body, func(n ast.Node) bool {
+        if _, ok := n.(*ast.FuncLit); ok {
+            return false
+        }
+
+        if ret, ok := n.(*ast.ReturnStmt); ok {
+            if _, name, ok := tailCallIdent(ret, isTarget); ok {
+                callees[name] = true
+            }
+        }
+
+        return true
+    })
+
+    return callees
+}
+
+// Tarjan 法で強連結成分 (SCC) を求める。funcs/edges はそれぞれ末尾呼び出し
+// グラフの頂点・辺。同じ SCC に属する関数は互いに (直接・間接に) 末尾呼び
+// 出ししあっており，相互再帰とみなせる。
+func stronglyConnectedComponents(funcs map[string]*ast.FuncDecl, edges map[string]map[string]bool) [][]string {
+    var names []string
+    for name := range funcs {
+        names = append(names, name)
+    }
+    sort.Strings(names) // 出力を決定的にするため
+
+    index := 0
+    indices := map[string]int{}
+    lowlink := map[string]int{}
+    onStack := map[string]bool{}
+    var stack []string
+    var sccs [][]string
+
+    var strongconnect func(v string)
+    strongconnect = func(v string) {
+        indices[v] = index
+        lowlink[v] = index
+        index++
+        stack = append(stack, v)
+        onStack[v] = true
+
+        var ws []string
+        for w := range edges[v] {
+            ws = append(ws, w)
+        }
+        sort.Strings(ws)
+
+        for _, w := range ws {
+            if _, visited := indices[w]; !visited {
+                strongconnect(w)
+                if lowlink[w] < lowlink[v] {
+                    lowlink[v] = lowlink[w]
+                }
+            } else if onStack[w] {
+                if indices[w] < lowlink[v] {
+                    lowlink[v] = indices[w]
+                }
+            }
+        }
+
+        if lowlink[v] == indices[v] {
+            var scc []string
+            for {
+                w := stack[len(stack)-1]
+                stack = stack[:len(stack)-1]
+                onStack[w] = false
+                scc = append(scc, w)
+                if w == v {
+                    break
+                }
+            }
+            sccs = append(sccs, scc)
+        }
+    }
+
+    for _, name := range names {
+        if _, visited := indices[name]; !visited {
+            strongconnect(name)
+        }
+    }
+
+    return sccs
+}
+
+// params を1つずつの *ast.Ident に展開する
+// (1つの *ast.Field が func f(a, b, c int) のように複数の変数名をまとめて
+// 宣言していることがあるため)
+func paramNames(params []*ast.Field) []*ast.Ident {
+    var names []*ast.Ident
+    for _, param := range params {
+        names = append(names, param.Names...)
+    }
+    return names
+}
+
+// 再帰呼び出しの引数の数が，パラメータの数とちょうど一致しているか確認する。
+// 可変長引数をまとめてスライスとして渡す呼び出し (e.g. f(xs...)) は
+// 引数1つとパラメータ1つの通常のケースと同じ形なので特別扱いの必要が無いが，
+// 可変長引数を個別の値として渡す呼び出しは，たとえ個数がパラメータ数と
+// 偶然一致していても tmp 変数がスライスではなく要素の型になってしまい
+// 代入が壊れるため，安全のため最適化の対象外とする。唯一受け付けるのは，
+// 可変長引数をそのまま xs... の形で転送している呼び出しだけ。
+func argsMatchParams(params []*ast.Field, call *ast.CallExpr) bool {
+    names := paramNames(params)
+    if len(call.Args) != len(names) {
+        return false
+    }
+
+    if !isVariadic(params) {
+        return true
+    }
+
+    if call.Ellipsis == token.NoPos {
+        return false
+    }
+
+    lastArg, ok := call.Args[len(call.Args)-1].(*ast.Ident)
+    return ok && lastArg.Name == names[len(names)-1].Name
+}
+
+// params の最後のフィールドが可変長引数 (...T) かどうか
+func isVariadic(params []*ast.Field) bool {
+    if len(params) == 0 {
+        return false
+    }
+    _, ok := params[len(params)-1].Type.(*ast.Ellipsis)
+    return ok
+}
+
+// root (1つの関数，あるいはファイル全体) に登場する識別子名をすべて集める。
+// 新しく tmp 変数名や trampoline 用の型・関数名を作るときに，
+// 既存の名前と衝突しないようにするため。
+func collectUsedNames(root ast.Node) map[string]bool {
+    used := map[string]bool{}
+
+    ast.Inspect(root, func(n ast.Node) bool {
+        if ident, ok := n.(*ast.Ident); ok {
+            used[ident.Name] = true
+        }
+        return true
+    })
+
+    return used
+}
+
+// used に含まれない名前を確保する。base がそのまま使えればそれを，
+// 衝突していれば連番を付けた名前を使う。
+func uniqueName(used map[string]bool, base string) string {
+    name := base
+    for i := 1; used[name]; i++ {
+        name = fmt.Sprintf("%s%d", base, i)
+    }
+    used[name] = true
+    return name
+}
+
+// 先頭の1文字を大文字にする (合成名を作るときの単純な CamelCase 化に使う)
+func exportCase(name string) string {
+    if name == "" {
+        return name
+    }
+    return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// used に含まれない tmp0, tmp1, ... という名前を n 個新しく作る。
+// 生成した名前はその場で used に登録し，以降の呼び出しと衝突しないようにする。
+func freshNames(used map[string]bool, n int) []*ast.Ident {
+    names := make([]*ast.Ident, 0, n)
+
+    next := 0
+    for len(names) < n {
+        candidate := fmt.Sprintf("tmp%d", next)
+        next++
+
+        if used[candidate] {
+            continue
+        }
+
+        used[candidate] = true
+        names = append(names, &ast.Ident{Name: candidate})
+    }
+
+    return names
+}
+
+// expr を深いコピーする。astutil.Apply で全ノードを辿りながらその場で
+// 複製して置き換えることで，元の AST のノード (特に位置情報) が
+// 新しく組み立てる文とエイリアスしないようにする。
+func cloneExpr(expr ast.Expr) ast.Expr {
+    root := shallowCopyNode(expr)
+
+    astutil.Apply(root, func(c *astutil.Cursor) bool {
+        if n := c.Node(); n != nil {
+            c.Replace(shallowCopyNode(n))
+        }
+        return true
+    }, nil)
+
+    return root.(ast.Expr)
+}
+
+// ノードを1段だけ複製する。子ノードへの参照はそのまま残すが，
+// astutil.Apply がそれぞれの子ノードにも再帰的に辿り着くので
+// 最終的に木全体が複製される。
+func shallowCopyNode(n ast.Node) ast.Node {
+    switch v := n.(type) {
+    case *ast.Ident:
+        cp := *v
+        return &cp
+    case *ast.BasicLit:
+        cp := *v
+        return &cp
+    case *ast.BinaryExpr:
+        cp := *v
+        return &cp
+    case *ast.UnaryExpr:
+        cp := *v
+        return &cp
+    case *ast.ParenExpr:
+        cp := *v
+        return &cp
+    case *ast.StarExpr:
+        cp := *v
+        return &cp
+    case *ast.SelectorExpr:
+        cp := *v
+        return &cp
+    case *ast.IndexExpr:
+        cp := *v
+        return &cp
+    case *ast.SliceExpr:
+        cp := *v
+        return &cp
+    case *ast.KeyValueExpr:
+        cp := *v
+        return &cp
+    case *ast.CompositeLit:
+        cp := *v
+        cp.Elts = append([]ast.Expr{}, v.Elts...)
+        return &cp
+    case *ast.CallExpr:
+        cp := *v
+        cp.Args = append([]ast.Expr{}, v.Args...)
+        return &cp
+    default:
+        return n
+    }
+}
+
+// name という名前のパラメータの型を探す
+func paramType(params []*ast.Field, name string) ast.Expr {
+    for _, param := range params {
+        for _, n := range param.Names {
+            if n.Name == name {
+                return param.Type
+            }
+        }
+    }
+    return nil
+}
+
+// *ast.FieldList を複製する (Params/Results をそのまま使い回すと，
+// 生成した別の関数と位置情報がエイリアスしてしまうため)
+func cloneFieldList(fl *ast.FieldList) *ast.FieldList {
+    if fl == nil {
+        return &ast.FieldList{}
+    }
+
+    fields := make([]*ast.Field, len(fl.List))
+    for i, f := range fl.List {
+        names := make([]*ast.Ident, len(f.Names))
+        for j, n := range f.Names {
+            names[j] = ast.NewIdent(n.Name)
+        }
+        fields[i] = &ast.Field{Names: names, Type: cloneExpr(f.Type)}
+    }
+
+    return &ast.FieldList{List: fields}
+}
+
+// *ast.FuncType を複製する (ラッパー関数に元の関数と同じシグネチャを
+// 持たせるために使う)
+func cloneFuncType(ft *ast.FuncType) *ast.FuncType {
+    return &ast.FuncType{Params: cloneFieldList(ft.Params), Results: cloneFieldList(ft.Results)}
+}
+
+// 引数リストを代入文に変換する。引数は任意の式 (Ident に限らない) を
+// 許容する: まず一時変数への := で args をすべて評価・退避してから，
+// 最後に一度の並列代入で本物のパラメータへ書き戻すことで，
+// return f(b, a) のような入れ替えや return f(a+1, g(b)) のような
+// 複合式も正しく扱える。
+func makeAssignStmts(used map[string]bool, params []*ast.Field, args []ast.Expr) []ast.Stmt {
+    names := paramNames(params)
+    tmpNames := freshNames(used, len(names))
+
+    stmts := []ast.Stmt{}
+
+    // 引数で渡していた式をすべて一時変数に代入する文
+    for i := range names {
+        assignTmp := &ast.AssignStmt{
+            Lhs: []ast.Expr{tmpNames[i]},
+            Tok: token.DEFINE,
+            Rhs: []ast.Expr{cloneExpr(args[i])},
+        }
+        stmts = append(stmts, assignTmp)
+    }
+
+    // 一時変数から値を取り出して新しい状態を代入する文 (1回の並列代入)
+    lhsIdents := make([]ast.Expr, 0, len(names))
+    rhsIdents := make([]ast.Expr, 0, len(tmpNames))
+    for i, name := range names {
+        lhsIdents = append(lhsIdents, &ast.Ident{Name: name.Name})
+        rhsIdents = append(rhsIdents, tmpNames[i])
+    }
+
+    assignAll := &ast.AssignStmt{
+        Lhs: lhsIdents,
+        Tok: token.ASSIGN,
+        Rhs: rhsIdents,
+    }
+    stmts = append(stmts, assignAll)
+
+    return stmts
+}
+
+// 末尾位置にある自己再帰の return を「引数の代入 + continue」に置き換える。
+// それ以外の return はそのまま残す (return は関数全体を抜けるので，
+// 生成する for ループの中に残しても意味は変わらない)。
+// if/else, switch, type switch, ネストしたブロックの中まで再帰的に辿る。
+func rewriteStmt(fn *ast.FuncDecl, cmap ast.CommentMap, used map[string]bool, stmt ast.Stmt) ([]ast.Stmt, bool) {
+    switch s := stmt.(type) {
+    case *ast.ReturnStmt:
+        callExpr, ok := recursiveCall(fn, s)
+        if !ok || !argsMatchParams(fn.Type.Params.List, callExpr) {
+            return []ast.Stmt{s}, false
+        }
+
+        assignStmts := makeAssignStmts(used, fn.Type.Params.List, callExpr.Args)
+        newStmts := append(assignStmts, &ast.BranchStmt{Tok: token.CONTINUE})
+        moveComments(cmap, s, newStmts[0])
+        return newStmts, true
+
+    case *ast.BlockStmt:
+        newList, changed := rewriteBlock(fn, cmap, used, s.List)
+        if changed {
+            s.List = newList
+        }
+        return []ast.Stmt{s}, changed
+
+    case *ast.IfStmt:
+        changed := false
+
+        newBody, ch := rewriteBlock(fn, cmap, used, s.Body.List)
+        if ch {
+            s.Body.List = newBody
+            changed = true
+        }
+
+        if s.Else != nil {
+            newElse, ch := rewriteElse(fn, cmap, used, s.Else)
+            if ch {
+                s.Else = newElse
+                changed = true
+            }
+        }
+
+        return []ast.Stmt{s}, changed
+
+    case *ast.SwitchStmt:
+        changed := rewriteCaseClauses(fn, cmap, used, s.Body.List)
+        return []ast.Stmt{s}, changed
+
+    case *ast.TypeSwitchStmt:
+        changed := rewriteCaseClauses(fn, cmap, used, s.Body.List)
+        return []ast.Stmt{s}, changed
+    }
+
+    // それ以外の文は末尾の return を含みえないのでそのまま
+    return []ast.Stmt{stmt}, false
+}
+
+// if の else 節 (ブロック，もしくは else if の IfStmt) を辿る
+func rewriteElse(fn *ast.FuncDecl, cmap ast.CommentMap, used map[string]bool, stmt ast.Stmt) (ast.Stmt, bool) {
+    switch s := stmt.(type) {
+    case *ast.BlockStmt:
+        newList, changed := rewriteBlock(fn, cmap, used, s.List)
+        if changed {
+            s.List = newList
+        }
+        return s, changed
+
+    case *ast.IfStmt:
+        newStmts, changed := rewriteStmt(fn, cmap, used, s)
+        return newStmts[0], changed
+    }
+
+    return stmt, false
+}
+
+// switch / type switch の各 case (CaseClause) の本文を辿る
+func rewriteCaseClauses(fn *ast.FuncDecl, cmap ast.CommentMap, used map[string]bool, clauses []ast.Stmt) bool {
+    changed := false
+
+    for _, clause := range clauses {
+        cc, ok := clause.(*ast.CaseClause)
+        if !ok {
+            continue
+        }
+
+        newBody, ch := rewriteBlock(fn, cmap, used, cc.Body)
+        if ch {
+            cc.Body = newBody
+            changed = true
+        }
+    }
+
+    return changed
+}
+
+// 文のリストを1つずつ rewriteStmt にかけ，結果をつなぎ合わせる
+// (1つの return が複数の文に展開されることがあるのでスライスを組み直す)
+func rewriteBlock(fn *ast.FuncDecl, cmap ast.CommentMap, used map[string]bool, list []ast.Stmt) ([]ast.Stmt, bool) {
+    changed := false
+    out := make([]ast.Stmt, 0, len(list))
+
+    for _, stmt := range list {
+        newStmts, ch := rewriteStmt(fn, cmap, used, stmt)
+        if ch {
+            changed = true
+        }
+        out = append(out, newStmts...)
+    }
+
+    return out, changed
+}
+
+// trampoline 化のために Step 関数の本体を書き換える際に使う情報
+type trampolineCtx struct {
+    funcs        map[string]*ast.FuncDecl // SCC の外も含めたファイル全体の関数
+    members      map[string]bool          // この SCC に属する関数名
+    argsTypeName map[string]string        // 関数名 -> 引数をまとめた構造体の型名
+    zeroName     string                   // 戻り値型のゼロ値を入れておく変数名
+    cmap         ast.CommentMap
+}
+
+// SCC 内の関数への末尾呼び出しを「thunk を組み立てて (zero値, thunk) を
+// 返す」形に，それ以外の return を「(元の値, nil) を返す」形に書き換える。
+// 対応できない形 (戻り値が複数式にまたがる，引数の数が合わない等) が
+// 見つかったら ok=false を返し，呼び出し側は SCC 全体への変換を諦める。
+func rewriteTrampolineStmt(ctx trampolineCtx, stmt ast.Stmt) ([]ast.Stmt, bool) {
+    switch s := stmt.(type) {
+    case *ast.ReturnStmt:
+        isTarget := func(name string) bool { return ctx.members[name] }
+
+        callExpr, callee, ok := tailCallIdent(s, isTarget)
+        if ok {
+            calleeParams := ctx.funcs[callee].Type.Params.List
+            if !argsMatchParams(calleeParams, callExpr) {
+                return nil, false
+            }
+
+            calleeNames := paramNames(calleeParams)
+            elts := make([]ast.Expr, 0, len(calleeNames))
+            for i, p := range calleeNames {
+                elts = append(elts, &ast.KeyValueExpr{Key: ast.NewIdent(p.Name), Value: cloneExpr(callExpr.Args[i])})
+            }
+
+            newRet := &ast.ReturnStmt{Results: []ast.Expr{
+                ast.NewIdent(ctx.zeroName),
+                &ast.CompositeLit{Type: ast.NewIdent(ctx.argsTypeName[callee]), Elts: elts},
+            }}
+            moveComments(ctx.cmap, s, newRet)
+            return []ast.Stmt{newRet}, true
+        }
+
+        if len(s.Results) != 1 {
+            return nil, false
+        }
+        newRet := &ast.ReturnStmt{Results: []ast.Expr{cloneExpr(s.Results[0]), ast.NewIdent("nil")}}
+        moveComments(ctx.cmap, s, newRet)
+        return []ast.Stmt{newRet}, true
+
+    case *ast.BlockStmt:
+        newList, ok := rewriteTrampolineBlock(ctx, s.List)
+        if !ok {
+            return nil, false
+        }
+        s.List = newList
+        return []ast.Stmt{s}, true
+
+    case *ast.IfStmt:
+        newBody, ok := rewriteTrampolineBlock(ctx, s.Body.List)
+        if !ok {
+            return nil, false
+        }
+        s.Body.List = newBody
+
+        if s.Else != nil {
+            newElse, ok := rewriteTrampolineElse(ctx, s.Else)
+            if !ok {
+                return nil, false
+            }
+            s.Else = newElse
+        }
+
+        return []ast.Stmt{s}, true
+
+    case *ast.SwitchStmt:
+        if !rewriteTrampolineCaseClauses(ctx, s.Body.List) {
+            return nil, false
+        }
+        return []ast.Stmt{s}, true
+
+    case *ast.TypeSwitchStmt:
+        if !rewriteTrampolineCaseClauses(ctx, s.Body.List) {
+            return nil, false
+        }
+        return []ast.Stmt{s}, true
+    }
+
+    // for/range/select/ラベル付き文などの中までは書き換えないので，
+    // そこに return が残っている場合は対応できない形として ok=false を返す
+    hasReturn := false
+    ast.Inspect(stmt, func(n ast.Node) bool {
+        switch n.(type) {
+        case *ast.FuncLit:
+            return false
+        case *ast.ReturnStmt:
+            hasReturn = true
+        }
+        return !hasReturn
+    })
+    return []ast.Stmt{stmt}, !hasReturn
+}
+
+func rewriteTrampolineElse(ctx trampolineCtx, stmt ast.Stmt) (ast.Stmt, bool) {
+    switch s := stmt.(type) {
+    case *ast.BlockStmt:
+        newList, ok := rewriteTrampolineBlock(ctx, s.List)
+        if !ok {
+            return nil, false
+        }
+        s.List = newList
+        return s, true
+
+    case *ast.IfStmt:
+        newStmts, ok := rewriteTrampolineStmt(ctx, s)
+        if !ok {
+            return nil, false
+        }
+        return newStmts[0], true
+    }
+
+    return stmt, true
+}
+
+func rewriteTrampolineCaseClauses(ctx trampolineCtx, clauses []ast.Stmt) bool {
+    for _, clause := range clauses {
+        cc, ok := clause.(*ast.CaseClause)
+        if !ok {
+            continue
+        }
+
+        newBody, ok := rewriteTrampolineBlock(ctx, cc.Body)
+        if !ok {
+            return false
+        }
+        cc.Body = newBody
+    }
+
+    return true
+}
+
+func rewriteTrampolineBlock(ctx trampolineCtx, list []ast.Stmt) ([]ast.Stmt, bool) {
+    out := make([]ast.Stmt, 0, len(list))
+
+    for _, stmt := range list {
+        newStmts, ok := rewriteTrampolineStmt(ctx, stmt)
+        if !ok {
+            return nil, false
+        }
+        out = append(out, newStmts...)
+    }
+
+    return out, true
+}
+
+// scc (互いに末尾呼び出ししあう関数の集まり) を trampoline 形式の宣言に
+// 組み直す。各関数は
+//
+//	func <name>Step(args...) (T, <base>Thunk)
+//
+// という「1手先」だけを計算する関数に変換され，末尾呼び出しは thunk を
+// 作って返すだけになる。実際の値が出るまで driver 関数 <base>Dispatch が
+// thunk を読み替えながらループし，元の関数名はその driver を呼ぶだけの
+// 薄いラッパーになる。戻り値の型がちょうど1つでない関数を含むなど，
+// この "mini" な実装でサポートしきれない組み合わせは ok=false を返す。
+func buildTrampoline(cmap ast.CommentMap, used map[string]bool, funcs map[string]*ast.FuncDecl, scc []string) ([]ast.Decl, bool) {
+    sort.Strings(scc) // 生成する名前を決定的にする
+
+    members := map[string]bool{}
+    for _, name := range scc {
+        members[name] = true
+    }
+
+    for _, name := range scc {
+        results := funcs[name].Type.Results
+        if results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+            return nil, false
+        }
+    }
+
+    base := ""
+    for _, name := range scc {
+        base += exportCase(name)
+    }
+    base = strings.ToLower(base[:1]) + base[1:]
+
+    thunkName := uniqueName(used, base+"Thunk")
+    dispatchName := uniqueName(used, base+"Dispatch")
+    markerName := uniqueName(used, "is"+exportCase(thunkName))
+
+    argsTypeName := map[string]string{}
+    stepName := map[string]string{}
+    for _, name := range scc {
+        argsTypeName[name] = uniqueName(used, name+"Args")
+        stepName[name] = uniqueName(used, name+"Step")
+    }
+
+    resultType := funcs[scc[0]].Type.Results.List[0].Type
+
+    var decls []ast.Decl
+
+    // thunk インターフェース: 区別のためのマーカーメソッドを1つ持つだけのサムタイプ
+    decls = append(decls, &ast.GenDecl{
+        Tok: token.TYPE,
+        Specs: []ast.Spec{&ast.TypeSpec{
+            Name: ast.NewIdent(thunkName),
+            Type: &ast.InterfaceType{Methods: &ast.FieldList{List: []*ast.Field{
+                {Names: []*ast.Ident{ast.NewIdent(markerName)}, Type: &ast.FuncType{Params: &ast.FieldList{}}},
+            }}},
+        }},
+    })
+
+    // 関数ごとの引数をまとめた構造体とマーカーメソッドの実装
+    for _, name := range scc {
+        names := paramNames(funcs[name].Type.Params.List)
+
+        fields := make([]*ast.Field, 0, len(names))
+        for _, p := range names {
+            fields = append(fields, &ast.Field{
+                Names: []*ast.Ident{ast.NewIdent(p.Name)},
+                Type:  cloneExpr(paramType(funcs[name].Type.Params.List, p.Name)),
+            })
+        }
+
+        decls = append(decls, &ast.GenDecl{
+            Tok: token.TYPE,
+            Specs: []ast.Spec{&ast.TypeSpec{
+                Name: ast.NewIdent(argsTypeName[name]),
+                Type: &ast.StructType{Fields: &ast.FieldList{List: fields}},
+            }},
+        })
+
+        decls = append(decls, &ast.FuncDecl{
+            Recv: &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("_")}, Type: ast.NewIdent(argsTypeName[name])}}},
+            Name: ast.NewIdent(markerName),
+            Type: &ast.FuncType{Params: &ast.FieldList{}},
+            Body: &ast.BlockStmt{},
+        })
+    }
+
+    // driver: 非 thunk な結果が出るまで，現在の thunk に応じた Step 関数を呼び続ける
+    cases := make([]ast.Stmt, 0, len(scc))
+    for _, name := range scc {
+        names := paramNames(funcs[name].Type.Params.List)
+
+        stepArgs := make([]ast.Expr, 0, len(names))
+        for _, p := range names {
+            stepArgs = append(stepArgs, &ast.SelectorExpr{X: ast.NewIdent("args"), Sel: ast.NewIdent(p.Name)})
+        }
+
+        cases = append(cases, &ast.CaseClause{
+            List: []ast.Expr{ast.NewIdent(argsTypeName[name])},
+            Body: []ast.Stmt{
+                &ast.AssignStmt{
+                    Lhs: []ast.Expr{ast.NewIdent("v"), ast.NewIdent("next")},
+                    Tok: token.DEFINE,
+                    Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent(stepName[name]), Args: stepArgs}},
+                },
+                &ast.IfStmt{
+                    Cond: &ast.BinaryExpr{X: ast.NewIdent("next"), Op: token.EQL, Y: ast.NewIdent("nil")},
+                    Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ReturnStmt{Results: []ast.Expr{ast.NewIdent("v")}}}},
+                },
+                &ast.AssignStmt{Lhs: []ast.Expr{ast.NewIdent("t")}, Tok: token.ASSIGN, Rhs: []ast.Expr{ast.NewIdent("next")}},
+            },
+        })
+    }
+
+    decls = append(decls, &ast.FuncDecl{
+        Name: ast.NewIdent(dispatchName),
+        Type: &ast.FuncType{
+            Params:  &ast.FieldList{List: []*ast.Field{{Names: []*ast.Ident{ast.NewIdent("t")}, Type: ast.NewIdent(thunkName)}}},
+            Results: &ast.FieldList{List: []*ast.Field{{Type: cloneExpr(resultType)}}},
+        },
+        Body: &ast.BlockStmt{List: []ast.Stmt{
+            &ast.ForStmt{Body: &ast.BlockStmt{List: []ast.Stmt{
+                &ast.TypeSwitchStmt{
+                    Assign: &ast.AssignStmt{
+                        Lhs: []ast.Expr{ast.NewIdent("args")},
+                        Tok: token.DEFINE,
+                        Rhs: []ast.Expr{&ast.TypeAssertExpr{X: ast.NewIdent("t")}},
+                    },
+                    Body: &ast.BlockStmt{List: cases},
+                },
+            }}},
+        }},
+    })
+
+    // 元の関数名は driver を呼ぶだけの薄いラッパーにする
+    for _, name := range scc {
+        names := paramNames(funcs[name].Type.Params.List)
+
+        elts := make([]ast.Expr, 0, len(names))
+        for _, p := range names {
+            elts = append(elts, &ast.KeyValueExpr{Key: ast.NewIdent(p.Name), Value: ast.NewIdent(p.Name)})
+        }
+
+        wrapper := &ast.FuncDecl{
+            Name: ast.NewIdent(name),
+            Type: cloneFuncType(funcs[name].Type),
+            Body: &ast.BlockStmt{List: []ast.Stmt{
+                &ast.ReturnStmt{Results: []ast.Expr{&ast.CallExpr{
+                    Fun:  ast.NewIdent(dispatchName),
+                    Args: []ast.Expr{&ast.CompositeLit{Type: ast.NewIdent(argsTypeName[name]), Elts: elts}},
+                }}},
+            }},
+        }
+        // 元の関数はここで公開名・シグネチャを引き継ぐだけの薄いラッパーに
+        // なるので，doc コメントも元の FuncDecl からこちらへ付け替える
+        moveComments(cmap, funcs[name], wrapper)
+        decls = append(decls, wrapper)
+    }
+
+    // Step 関数: 元の本文をベースに，SCC 内への末尾呼び出しを thunk を
+    // 組み立てて返す形に書き換える
+    for _, name := range scc {
+        fn := funcs[name]
+
+        bodyUsed := collectUsedNames(fn)
+        zeroName := uniqueName(bodyUsed, "zero")
+
+        ctx := trampolineCtx{funcs: funcs, members: members, argsTypeName: argsTypeName, zeroName: zeroName, cmap: cmap}
+        newList, ok := rewriteTrampolineBlock(ctx, fn.Body.List)
+        if !ok {
+            return nil, false
+        }
+
+        zeroDecl := &ast.DeclStmt{Decl: &ast.GenDecl{
+            Tok:   token.VAR,
+            Specs: []ast.Spec{&ast.ValueSpec{Names: []*ast.Ident{ast.NewIdent(zeroName)}, Type: cloneExpr(resultType)}},
+        }}
+
+        decls = append(decls, &ast.FuncDecl{
+            Name: ast.NewIdent(stepName[name]),
+            Type: &ast.FuncType{
+                Params: cloneFieldList(fn.Type.Params),
+                Results: &ast.FieldList{List: []*ast.Field{
+                    {Type: cloneExpr(resultType)},
+                    {Type: ast.NewIdent(thunkName)},
+                }},
+            },
+            Body: &ast.BlockStmt{List: append([]ast.Stmt{zeroDecl}, newList...)},
+        })
+    }
+
+    return decls, true
+}
+
+// scc に属する関数の宣言を，trampoline 形式の宣言群に置き換える。
+// 対応できない組み合わせだった場合は node を変更せず false を返す。
+func applyTrampoline(node *ast.File, cmap ast.CommentMap, used map[string]bool, funcs map[string]*ast.FuncDecl, scc []string) bool {
+    newDecls, ok := buildTrampoline(cmap, used, funcs, scc)
+    if !ok {
+        return false
+    }
+
+    members := map[string]bool{}
+    for _, name := range scc {
+        members[name] = true
+    }
+
+    insertAt := -1
+    var kept []ast.Decl
+    for _, decl := range node.Decls {
+        fn, ok := decl.(*ast.FuncDecl)
+        if ok && fn.Recv == nil && members[fn.Name.Name] {
+            if insertAt == -1 {
+                insertAt = len(kept)
+            }
+            continue
+        }
+        kept = append(kept, decl)
+    }
+    if insertAt == -1 {
+        insertAt = len(kept)
+    }
+
+    result := make([]ast.Decl, 0, len(kept)+len(newDecls))
+    result = append(result, kept[:insertAt]...)
+    result = append(result, newDecls...)
+    result = append(result, kept[insertAt:]...)
+    node.Decls = result
+
+    return true
+}
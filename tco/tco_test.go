@@ -0,0 +1,424 @@
+package tco
+
+import (
+    "go/parser"
+    "go/token"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// Transform を通した結果を文字列として返す。
+func runTransform(t *testing.T, src string) string {
+    t.Helper()
+
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+
+    if _, err := Transform(fset, file); err != nil {
+        t.Fatalf("Transform: %v", err)
+    }
+
+    out, err := Format(fset, file)
+    if err != nil {
+        t.Fatalf("Format: %v", err)
+    }
+
+    return string(out)
+}
+
+func TestMakeAssignStmtsArbitraryArgs(t *testing.T) {
+    cases := []struct {
+        name string
+        src  string
+        want []string // 出力に含まれているべき部分文字列
+    }{
+        {
+            name: "swap",
+            src: `package p
+func f(a, b int) int {
+	if a == 0 {
+		return b
+	}
+	return f(b, a)
+}`,
+            want: []string{"for {", "tmp0 := b", "tmp1 := a", "a, b = tmp0, tmp1", "continue"},
+        },
+        {
+            name: "compound expressions",
+            src: `package p
+func g(x int) int { return x }
+func f(a, b int) int {
+	if a == 0 {
+		return b
+	}
+	return f(a+1, g(b))
+}`,
+            want: []string{"tmp0 := a + 1", "tmp1 := g(b)", "a, b = tmp0, tmp1"},
+        },
+        {
+            name: "tmp name collision is avoided",
+            src: `package p
+func f(a, tmp0 int) int {
+	if a == 0 {
+		return tmp0
+	}
+	return f(tmp0, a)
+}`,
+            want: []string{"tmp1 := tmp0", "tmp2 := a", "a, tmp0 = tmp1, tmp2"},
+        },
+    }
+
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got := runTransform(t, tc.src)
+            for _, want := range tc.want {
+                if !strings.Contains(got, want) {
+                    t.Errorf("output missing %q\ngot:\n%s", want, got)
+                }
+            }
+        })
+    }
+}
+
+func TestVariadicCallsRewrittenOnlyForBareForward(t *testing.T) {
+    cases := []struct {
+        name string
+        src  string
+        want []string // 出力に含まれているべき部分文字列 (書き換えられた/書き換えられていない証拠)
+    }{
+        {
+            // 可変長引数に個別の値を渡す呼び出しは，個数が偶然パラメータ数と
+            // 一致していても tmp 変数がスライス型にならず代入が壊れるので，
+            // 書き換え対象にしてはいけない。
+            name: "individual values into variadic slot",
+            src: `package p
+func f(a int, b ...int) int {
+	if a == 0 {
+		return len(b)
+	}
+	return f(a-1, 5)
+}`,
+            want: []string{"return f(a-1, 5)"},
+        },
+        {
+            // b... のように既存の可変長引数をそのまま転送する呼び出しは
+            // 安全なので，通常どおり書き換えてよい。
+            name: "bare forward of the variadic parameter",
+            src: `package p
+func f(a int, b ...int) int {
+	if a == 0 {
+		return len(b)
+	}
+	return f(a-1, b...)
+}`,
+            want: []string{"for {", "tmp0 := a - 1", "tmp1 := b", "a, b = tmp0, tmp1", "continue"},
+        },
+    }
+
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got := runTransform(t, tc.src)
+            for _, want := range tc.want {
+                if !strings.Contains(got, want) {
+                    t.Errorf("output missing %q\ngot:\n%s", want, got)
+                }
+            }
+        })
+    }
+}
+
+func TestBranchingControlFlow(t *testing.T) {
+    cases := []struct {
+        name string
+        src  string
+        want []string
+    }{
+        {
+            name: "if/else if/else chain",
+            src: `package p
+func f(n, acc int) int {
+	if n == 0 {
+		return acc
+	} else if n < 0 {
+		return acc
+	} else {
+		return f(n-1, acc*n)
+	}
+}`,
+            want: []string{"for {", "tmp0 := n - 1", "tmp1 := acc * n", "n, acc = tmp0, tmp1", "continue"},
+        },
+        {
+            name: "switch tail return",
+            src: `package p
+func f(n, acc int) int {
+	switch {
+	case n == 0:
+		return acc
+	default:
+		return f(n-1, acc*n)
+	}
+}`,
+            want: []string{"for {", "tmp0 := n - 1", "tmp1 := acc * n", "n, acc = tmp0, tmp1", "continue"},
+        },
+        {
+            name: "type switch tail return",
+            src: `package p
+func f(n interface{}) interface{} {
+	switch v := n.(type) {
+	case int:
+		if v == 0 {
+			return v
+		}
+		return f(v - 1)
+	default:
+		return n
+	}
+}`,
+            want: []string{"for {", "tmp0 := v - 1", "n = tmp0", "continue"},
+        },
+    }
+
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            got := runTransform(t, tc.src)
+            for _, want := range tc.want {
+                if !strings.Contains(got, want) {
+                    t.Errorf("output missing %q\ngot:\n%s", want, got)
+                }
+            }
+        })
+    }
+}
+
+func TestMultiReturnTailRecursion(t *testing.T) {
+    src := `package p
+func fact(n, acc int) (int, error) {
+	if n == 0 {
+		return acc, nil
+	}
+	return fact(n-1, n*acc), nil
+}`
+
+    got := runTransform(t, src)
+
+    for _, want := range []string{"for {", "tmp0 := n - 1", "tmp1 := n * acc", "n, acc = tmp0, tmp1", "continue", "return acc, nil"} {
+        if !strings.Contains(got, want) {
+            t.Errorf("output missing %q\ngot:\n%s", want, got)
+        }
+    }
+}
+
+// TestTrampolineMutualRecursion は even/odd のような相互末尾再帰が
+// trampoline 形式に書き換わり，実際に go build/go run できて元の値と
+// 同じ結果を返すことを確認する。
+func TestTrampolineMutualRecursion(t *testing.T) {
+    goBin, err := exec.LookPath("go")
+    if err != nil {
+        t.Skip("go toolchain not available")
+    }
+
+    src := `package p
+
+func even(n int) bool {
+	if n == 0 {
+		return true
+	}
+	return odd(n - 1)
+}
+
+func odd(n int) bool {
+	if n == 0 {
+		return false
+	}
+	return even(n - 1)
+}
+`
+
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+
+    changed, err := TransformWithTrampoline(fset, file)
+    if err != nil {
+        t.Fatalf("TransformWithTrampoline: %v", err)
+    }
+    if !changed {
+        t.Fatalf("TransformWithTrampoline reported changed=false for mutually tail-recursive even/odd")
+    }
+
+    out, err := Format(fset, file)
+    if err != nil {
+        t.Fatalf("Format: %v", err)
+    }
+
+    for _, want := range []string{"Thunk", "Dispatch", "Args", "for {", "switch args := t.(type)"} {
+        if !strings.Contains(string(out), want) {
+            t.Errorf("trampoline output missing %q\ngot:\n%s", want, out)
+        }
+    }
+
+    dir := t.TempDir()
+    mainSrc := strings.Replace(string(out), "package p\n", "package main\n", 1) + `
+func main() {
+	println(even(10), odd(10), even(7), odd(7))
+}
+`
+    mainPath := filepath.Join(dir, "main.go")
+    if err := os.WriteFile(mainPath, []byte(mainSrc), 0o644); err != nil {
+        t.Fatalf("write generated source: %v", err)
+    }
+
+    cmd := exec.Command(goBin, "run", mainPath)
+    runOut, err := cmd.CombinedOutput()
+    if err != nil {
+        t.Fatalf("go run generated trampoline failed: %v\n%s", err, runOut)
+    }
+
+    got := strings.TrimSpace(string(runOut))
+    want := "true false false true"
+    if got != want {
+        t.Errorf("generated trampoline produced %q, want %q", got, want)
+    }
+}
+
+// TestTrampolineDocCommentsPreserved は，trampoline 化される関数の doc
+// コメントが，公開名とシグネチャを引き継ぐラッパー関数にちゃんと付け替わる
+// ことを確認する (通常のループへの書き換えとは別の経路なので個別に検証する)。
+func TestTrampolineDocCommentsPreserved(t *testing.T) {
+    src := `package p
+
+// even reports whether n is even.
+func even(n int) bool {
+	if n == 0 {
+		return true
+	}
+	return odd(n - 1)
+}
+
+// odd reports whether n is odd.
+func odd(n int) bool {
+	if n == 0 {
+		return false
+	}
+	return even(n - 1)
+}
+`
+
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+
+    if _, err := TransformWithTrampoline(fset, file); err != nil {
+        t.Fatalf("TransformWithTrampoline: %v", err)
+    }
+
+    out, err := Format(fset, file)
+    if err != nil {
+        t.Fatalf("Format: %v", err)
+    }
+
+    got := string(out)
+    for _, want := range []string{"// even reports whether n is even.", "// odd reports whether n is odd."} {
+        if !strings.Contains(got, want) {
+            t.Errorf("trampoline output missing doc comment %q\ngot:\n%s", want, got)
+        }
+    }
+}
+
+func TestCommentsPreserved(t *testing.T) {
+    src := `package p
+
+func f(a, b int) int {
+	if a == 0 {
+		// base case
+		return b
+	}
+	// recursive step
+	return f(b, a)
+}`
+
+    got := runTransform(t, src)
+
+    for _, want := range []string{"// base case", "// recursive step"} {
+        if !strings.Contains(got, want) {
+            t.Errorf("output missing comment %q\ngot:\n%s", want, got)
+        }
+    }
+}
+
+func TestTransformReportsChanged(t *testing.T) {
+    src := `package p
+
+func f(a int) int {
+	return a
+}`
+
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+
+    changed, err := Transform(fset, file)
+    if err != nil {
+        t.Fatalf("Transform: %v", err)
+    }
+    if changed {
+        t.Errorf("Transform reported changed=true for a function with no tail recursion")
+    }
+}
+
+// TestUnrewritableTailCallLeavesFunctionUntouched は，hasTailRecursion が
+// 見つける再帰呼び出しのうち，rewriteBlock が実際には書き換えられない
+// もの (for ループの中にあるなど) について，本文を意味のない for{} で
+// 包んだり changed=true を報告したりしないことを確認する。
+func TestUnrewritableTailCallLeavesFunctionUntouched(t *testing.T) {
+    src := `package p
+
+func f(n, acc int) int {
+	for i := 0; i < 1; i++ {
+		if n == 0 {
+			return acc
+		}
+		return f(n-1, acc*n)
+	}
+	return acc
+}`
+
+    fset := token.NewFileSet()
+    file, err := parser.ParseFile(fset, "test.go", src, parser.ParseComments)
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+
+    changed, err := Transform(fset, file)
+    if err != nil {
+        t.Fatalf("Transform: %v", err)
+    }
+    if changed {
+        t.Errorf("Transform reported changed=true despite not rewriting the recursive call inside the for loop")
+    }
+
+    out, err := Format(fset, file)
+    if err != nil {
+        t.Fatalf("Format: %v", err)
+    }
+
+    got := string(out)
+    if strings.Contains(got, "continue") {
+        t.Errorf("output unexpectedly contains continue\ngot:\n%s", got)
+    }
+    if !strings.Contains(got, "return f(n-1, acc*n)") {
+        t.Errorf("output should leave the unrewritable recursive call untouched\ngot:\n%s", got)
+    }
+}